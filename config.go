@@ -1,6 +1,7 @@
 package apiserver
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +11,22 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
-// healthCheck is the default health check call
-func healthCheck(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+// defaultHealthCheck is the default health check call. When
+// MaxRequestsInFlight has been configured, the payload also reports the
+// current in-flight request count so operators can observe saturation.
+func (c *Config) defaultHealthCheck(w http.ResponseWriter, r *http.Request) {
+	payload := map[string]interface{}{"ok": true}
+	if c.inFlightGauge != nil {
+		payload["inFlight"] = int(atomic.LoadInt32(c.inFlightGauge))
+		payload["maxInFlight"] = c.maxInFlight
+	}
+	json.NewEncoder(w).Encode(payload)
 }
 
 // validPrefix ensures the prefix is correct for a SubRouter
@@ -40,9 +52,9 @@ func validPrefix(prefix string, requireTrailingSlash bool) string {
 func NewConfig() *Config {
 	c := &Config{
 		l:     log.Default(),
-		hc:    healthCheck,
 		specs: []*APISpecification{},
 	}
+	c.hc = c.defaultHealthCheck
 
 	// Environment specific details
 	c.Port(c.getDefaultableEnvAsInt("PORT", "8080"))
@@ -54,24 +66,176 @@ func NewConfig() *Config {
 	c.WriteTimeout(c.getDefaultableEnvAsInt("WRITETIMEOUT", "15"))  // seconds
 	c.ReadTimeout(c.getDefaultableEnvAsInt("READTIMEOUT", "15"))    // seconds
 	c.ExitTimeout(c.getDefaultableEnvAsInt("EXITTIMEOUT", "10"))    // seconds
+	c.BindAddress(c.getDefaultableEnv("BINDADDRESS", "127.0.0.1"))  // interface to listen on
+	c.RequestTimeoutMessage("request timed out")
 
 	return c
 }
 
 // Config allows the Server to be configured as required
 type Config struct {
-	apiPrefix    string
-	domain       string
-	exitTimeout  int
-	hc           http.HandlerFunc
-	healthPath   string
-	l            *log.Logger
-	port         string
-	readTimeout  int
-	scheme       string
-	specs        []*APISpecification
-	subdomain    string
-	writeTimeout int
+	apiPrefix             string
+	domain                string
+	exitTimeout           int
+	hc                    http.HandlerFunc
+	healthPath            string
+	l                     *log.Logger
+	port                  string
+	readTimeout           int
+	scheme                string
+	specs                 []*APISpecification
+	subdomain             string
+	writeTimeout          int
+	middlewares           []Middleware
+	maxInFlight           int
+	inFlightGauge         *int32
+	longRunningRE         *regexp.Regexp
+	bindAddress           string
+	tlsCertFile           string
+	tlsKeyFile            string
+	autoTLSDomains        []string
+	clientCAFile          string
+	clientAuthType        tls.ClientAuthType
+	cors                  *CORSOptions
+	defaultRequestTimeout time.Duration
+	requestTimeoutMessage string
+	metricsPath           string
+	metricsUser           string
+	metricsPass           string
+	wsUpgrader            *websocket.Upgrader
+}
+
+// WebSocketUpgrader overrides the Upgrader used for paths registered via
+// APISpecification.AddWebSocketPath, letting callers configure origin
+// checks, buffer sizes and subprotocols. If not set, a safe default is
+// used that rejects cross-origin upgrades unless CORS explicitly
+// whitelists the origin.
+func (c *Config) WebSocketUpgrader(u *websocket.Upgrader) *Config {
+	c.wsUpgrader = u
+	return c
+}
+
+// Metrics enables a Prometheus /metrics endpoint, registered on the root
+// router rather than under ApiPathPrefix so it isn't gated by the API's
+// content-type rules. path defaults to "/metrics" if empty. See also
+// MetricsAuth to protect the endpoint with basic auth.
+func (c *Config) Metrics(path string) *Config {
+	if len(path) == 0 {
+		path = "/metrics"
+	}
+	c.metricsPath = validPrefix(strings.TrimPrefix(path, "/"), false)
+	return c
+}
+
+// MetricsAuth protects the Metrics endpoint with HTTP basic auth.
+func (c *Config) MetricsAuth(user, pass string) *Config {
+	c.metricsUser = user
+	c.metricsPass = pass
+	return c
+}
+
+// DefaultRequestTimeout sets the fallback per-route request timeout
+// applied to any path not registered via an AddXPathWithTimeout method.
+// Zero (the default) disables the fallback, leaving such routes bounded
+// only by WriteTimeout.
+func (c *Config) DefaultRequestTimeout(d time.Duration) *Config {
+	c.defaultRequestTimeout = d
+	return c
+}
+
+// RequestTimeoutMessage overrides the response body written when a route
+// exceeds its request timeout, alongside a 503 Service Unavailable.
+func (c *Config) RequestTimeoutMessage(msg string) *Config {
+	c.requestTimeoutMessage = msg
+	return c
+}
+
+// CORS enables cross-origin resource sharing for the API, installing a
+// middleware that sets the appropriate Access-Control-* response headers
+// and auto-registering an OPTIONS preflight handler for every declared
+// route. Allowed methods are derived per-route from the methods actually
+// registered against it, rather than configured in opts.
+func (c *Config) CORS(opts CORSOptions) *Config {
+	c.cors = &opts
+	return c
+}
+
+// BindAddress sets the interface the Server listens on, e.g. "127.0.0.1"
+// to accept only local connections, or "0.0.0.0" to accept connections on
+// every interface.
+func (c *Config) BindAddress(addr string) *Config {
+	c.bindAddress = addr
+	return c
+}
+
+// TLS configures the Server to serve HTTPS using the given certificate
+// and private key files.
+func (c *Config) TLS(certFile, keyFile string) *Config {
+	c.tlsCertFile = certFile
+	c.tlsKeyFile = keyFile
+	return c
+}
+
+// autoTLSCacheDir is where AutoTLS caches ACME-issued certificates.
+const autoTLSCacheDir = "certs"
+
+// AutoTLS configures the Server to serve HTTPS using certificates issued
+// automatically via ACME (golang.org/x/crypto/acme/autocert) for the
+// given domains. It takes precedence over TLS if both are configured.
+func (c *Config) AutoTLS(domains ...string) *Config {
+	c.autoTLSDomains = domains
+	return c
+}
+
+// ClientCA configures the Server to require and verify client certificates
+// signed by the CA in caFile, according to mode, enabling mutual TLS.
+// Verified peer certificates are available to handlers via
+// r.TLS.PeerCertificates.
+func (c *Config) ClientCA(caFile string, mode tls.ClientAuthType) *Config {
+	c.clientCAFile = caFile
+	c.clientAuthType = mode
+	return c
+}
+
+// MaxRequestsInFlight bounds the number of requests processed concurrently
+// to n, using a semaphore installed ahead of every registered APIPath.
+// Once the semaphore is full, further requests receive 429 Too Many
+// Requests until a slot frees up. Requests matching LongRunningRequestRE
+// bypass the semaphore entirely. See also HealthCheck, whose default
+// payload reports the current in-flight count once this is configured.
+func (c *Config) MaxRequestsInFlight(n int) *Config {
+	if n <= 0 {
+		c.l.Panicf("invalid max requests in flight (%d)", n)
+	}
+	c.maxInFlight = n
+	c.inFlightGauge = new(int32)
+	return c
+}
+
+// LongRunningRequestRE compiles pattern and matches it against
+// "METHOD path" (e.g. "GET /api/v1/watch") to identify long-running
+// requests, such as streaming or watch endpoints, that should bypass the
+// MaxRequestsInFlight semaphore rather than holding a slot indefinitely.
+func (c *Config) LongRunningRequestRE(pattern string) *Config {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		c.l.Panicf("invalid long running request pattern (%s): %v", pattern, err)
+	}
+	c.longRunningRE = re
+	return c
+}
+
+// Use appends a Middleware to the Config's chain, applied around every
+// APIHandler registered against the Server, for all APISpecifications.
+// Middlewares run in the order they are added: the first added is the
+// outermost, and runs before those added later, before any middleware
+// added via APISpecification.Use, before the APIHandler itself. This
+// gives global concerns (logging, request IDs, panic recovery, auth)
+// first refusal on every request, matching the "alice"-style chain
+// pattern common in Go HTTP frameworks.
+func (c *Config) Use(mw Middleware) *Config {
+	c.middlewares = append(c.middlewares, mw)
+	return c
 }
 
 // getDefaultableEnv returns the value of an environment variable or default
@@ -184,8 +348,7 @@ func (c *Config) NewSpecification(prefix string) *APISpecification {
 	spec := &APISpecification{
 		l:      c.l,
 		prefix: prefix,
-		gets:   []APIPath{},
-		posts:  []APIPath{},
+		paths:  map[string][]APIPath{},
 	}
 
 	c.specs = append(c.specs, spec)
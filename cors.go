@@ -0,0 +1,86 @@
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSOptions configures cross-origin resource sharing for an API,
+// applied via Config.CORS. Allowed methods are not configured here: they
+// are derived per-route from the methods actually registered against
+// that route.
+type CORSOptions struct {
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	MaxAge           int
+	AllowCredentials bool
+}
+
+// originAllowed reports whether origin is permitted by o.
+func (o CORSOptions) originAllowed(origin string) bool {
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyHeaders writes the CORS response headers for origin onto w, for a
+// route allowing methods.
+func (o CORSOptions) applyHeaders(w http.ResponseWriter, origin string, methods []string) {
+	switch {
+	case len(o.AllowedOrigins) == 1 && o.AllowedOrigins[0] == "*":
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	case o.originAllowed(origin):
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	if len(methods) > 0 {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	}
+	if len(o.AllowedHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(o.AllowedHeaders, ", "))
+	}
+	if o.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(o.MaxAge))
+	}
+	if o.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// corsMiddleware returns a Middleware that applies opts' CORS response
+// headers, for a route allowing methods, to every actual (non-preflight)
+// request.
+func corsMiddleware(opts CORSOptions, methods []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			opts.applyHeaders(w, r.Header.Get("Origin"), methods)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// corsPreflightHandler answers an OPTIONS preflight request for a route
+// registered under methods.
+func corsPreflightHandler(opts CORSOptions, methods []string) http.HandlerFunc {
+	allowed := append(append([]string{}, methods...), http.MethodOptions)
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts.applyHeaders(w, r.Header.Get("Origin"), allowed)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// methodsByPath returns, for each path registered on spec, the list of
+// HTTP methods it is registered under.
+func methodsByPath(spec *APISpecification) map[string][]string {
+	m := map[string][]string{}
+	for method, paths := range spec.paths {
+		for _, p := range paths {
+			m[p.path] = append(m[p.path], method)
+		}
+	}
+	return m
+}
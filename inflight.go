@@ -0,0 +1,56 @@
+package apiserver
+
+import (
+	"net/http"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// inFlightLimiter returns a Middleware that bounds the number of requests
+// processed concurrently to n, using a buffered channel of size n as a
+// semaphore. Requests whose "METHOD path" matches longRunning bypass the
+// semaphore entirely, so long-running endpoints (streaming, watch, ...)
+// can't hold a slot open indefinitely and starve the rest of the API.
+// Requests matching a route template in exempt, such as the healthcheck or
+// a registered WebSocket path, bypass it too: the former so the reported
+// in-flight count reflects real load rather than the healthcheck's own
+// probing, the latter because an open WebSocket connection would otherwise
+// hold a slot for its entire lifetime. When the semaphore is full, the
+// middleware responds 429 Too Many Requests with a Retry-After header,
+// without invoking next. gauge, if non-nil, is kept up to date with the
+// current in-flight count so it can be surfaced elsewhere, such as the
+// healthcheck payload.
+func inFlightLimiter(n int, longRunning *regexp.Regexp, gauge *int32, exempt map[string]bool) Middleware {
+	sem := make(chan struct{}, n)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if longRunning != nil && longRunning.MatchString(r.Method+" "+r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil && exempt[tmpl] {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				if gauge != nil {
+					atomic.AddInt32(gauge, 1)
+					defer atomic.AddInt32(gauge, -1)
+				}
+				next.ServeHTTP(w, r)
+			default:
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors installed by Config.Metrics.
+type metrics struct {
+	path     string
+	authUser string
+	authPass string
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+// newMetrics builds the apiserver_* collectors and registers them against
+// the default Prometheus registry. The Go runtime and process collectors
+// are not registered here: client_golang already installs them on the
+// default registry via its own package init, and registering them again
+// would panic with a duplicate-collector error.
+func newMetrics(path, authUser, authPass string) *metrics {
+	m := &metrics{
+		path:     path,
+		authUser: authUser,
+		authPass: authPass,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apiserver_requests_total",
+			Help: "Total number of requests processed, by method, path and status code.",
+		}, []string{"method", "path", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "apiserver_request_duration_seconds",
+			Help: "Request latency in seconds, by method and path.",
+		}, []string{"method", "path"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "apiserver_in_flight_requests",
+			Help: "Number of requests currently being processed.",
+		}),
+	}
+
+	prometheus.MustRegister(m.requests, m.duration, m.inFlight)
+
+	return m
+}
+
+// middlewareFunc is a Middleware that records apiserver_requests_total
+// and apiserver_request_duration_seconds for every request, and tracks
+// apiserver_in_flight_requests while it is being processed. The path
+// label uses the matched mux route template, not the raw URL, to avoid
+// cardinality blowups from path variables.
+func (m *metrics) middlewareFunc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		started := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		path := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				path = tmpl
+			}
+		}
+
+		m.requests.WithLabelValues(r.Method, path, strconv.Itoa(sw.status)).Inc()
+		m.duration.WithLabelValues(r.Method, path).Observe(time.Since(started).Seconds())
+	})
+}
+
+// handler serves the registered collectors, protected with basic auth if
+// authUser was set via Config.MetricsAuth.
+func (m *metrics) handler() http.HandlerFunc {
+	h := promhttp.Handler()
+	if m.authUser == "" {
+		return h.ServeHTTP
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != m.authUser || pass != m.authPass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
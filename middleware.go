@@ -0,0 +1,131 @@
+package apiserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Middleware wraps an http.Handler with additional behaviour, using the
+// same signature as the "alice"-style chain pattern common in Go HTTP
+// frameworks, so existing third-party middlewares can be used directly.
+type Middleware func(http.Handler) http.Handler
+
+// chain composes ms into a single http.Handler wrapping final, applying
+// the middlewares in the order they appear in ms: ms[0] is outermost and
+// runs first, ms[len(ms)-1] runs last, immediately before final.
+func chain(ms []Middleware, final http.Handler) http.Handler {
+	h := final
+	for i := len(ms) - 1; i >= 0; i-- {
+		h = ms[i](h)
+	}
+	return h
+}
+
+// requestIDKey is the context key under which RequestID stores the
+// generated identifier.
+type requestIDKey struct{}
+
+// RequestIDHeader is the response header populated by RequestID.
+const RequestIDHeader = "X-Request-Id"
+
+// RequestID is a built-in Middleware that assigns a unique identifier to
+// each request, exposing it via the RequestIDHeader response header and
+// via the request context (retrievable with RequestIDFromContext).
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID()
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the request identifier assigned by
+// RequestID, or "" if RequestID was not applied to the request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random hex-encoded identifier suitable for use
+// as a request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Recoverer is a built-in Middleware that recovers from panics raised by
+// downstream handlers, logging the panic and responding with a 500
+// Internal Server Error rather than crashing the server.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("panic handling %s %s: %v", r.Method, r.URL.Path, rec)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AccessLog returns a built-in Middleware that logs one line per request
+// to l, recording the method, path, status code and duration.
+func AccessLog(l *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			l.Printf("%s %s %d %s", r.Method, r.URL.Path, sw.status, time.Since(started))
+		})
+	}
+}
+
+// statusWriter captures the status code written to an http.ResponseWriter
+// so that it can be reported by middlewares such as AccessLog. It forwards
+// Hijack and Flush to the underlying ResponseWriter so that wrapping it
+// doesn't break WebSocket upgrades or streaming responses.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets statusWriter be used ahead of a WebSocket upgrade or other
+// handler that takes over the connection via http.Hijacker.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not implement http.Hijacker")
+	}
+	return hj.Hijack()
+}
+
+// Flush lets statusWriter be used ahead of a handler that streams its
+// response via http.Flusher.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped ResponseWriter, so http.ResponseController
+// can reach capabilities statusWriter doesn't itself forward.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
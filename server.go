@@ -2,6 +2,8 @@ package apiserver
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,16 +12,25 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Server initialises and runs a http.Server to handle http requests
 type Server struct {
-	exitTimeout int
-	logger      *log.Logger
-	srv         *http.Server
+	exitTimeout     int
+	logger          *log.Logger
+	srv             *http.Server
+	certFile        string
+	keyFile         string
+	useTLS          bool
+	autocertManager *autocert.Manager
+	wsMu            sync.Mutex
+	wsConns         map[*websocket.Conn]struct{}
 }
 
 // init performs default initialisation and then applies
@@ -33,26 +44,47 @@ func (s *Server) init(config *Config) error {
 
 	r := mux.NewRouter()
 
+	// Instrument every request, including those later rejected by the
+	// in-flight limiter, with Prometheus collectors, and serve them on
+	// the root router so the endpoint isn't gated by the API's
+	// content-type rules.
+	var metricsRoute *mux.Route
+	if len(config.metricsPath) > 0 {
+		pm := newMetrics(config.metricsPath, config.metricsUser, config.metricsPass)
+		r.Use(mux.MiddlewareFunc(pm.middlewareFunc))
+		metricsRoute = r.HandleFunc(pm.path, pm.handler())
+	}
+
 	// Subroute based on domain and subdomain if specified
 	d := r
 	if strings.ToLower(config.domain) != "localhost" {
 		d = r.Host(fmt.Sprintf("%s.%s", config.subdomain, config.domain)).Subrouter()
 	}
 
-	// Api only, with only GET and POST requests processed, where
-	// POST requests must provide JSON based request objects
-	get := d.
-		PathPrefix(config.apiPrefix).
-		Methods("GET").
-		Schemes(config.scheme).Subrouter()
+	// Api only, one subrouter per method. The JSON content-type
+	// restriction is no longer a blanket rule on POST: it is opt-in per
+	// path, via RequireJSON().
+	routers := map[string]*mux.Router{
+		http.MethodGet:    d.PathPrefix(config.apiPrefix).Methods(http.MethodGet).Schemes(config.scheme).Subrouter(),
+		http.MethodPost:   d.PathPrefix(config.apiPrefix).Methods(http.MethodPost).Schemes(config.scheme).Subrouter(),
+		http.MethodPut:    d.PathPrefix(config.apiPrefix).Methods(http.MethodPut).Schemes(config.scheme).Subrouter(),
+		http.MethodDelete: d.PathPrefix(config.apiPrefix).Methods(http.MethodDelete).Schemes(config.scheme).Subrouter(),
+		http.MethodPatch:  d.PathPrefix(config.apiPrefix).Methods(http.MethodPatch).Schemes(config.scheme).Subrouter(),
+	}
 
-	post := d.
-		PathPrefix(config.apiPrefix).
-		HeadersRegexp("Content-Type", "application/json").
-		Methods("POST").
-		Schemes(config.scheme).Subrouter()
+	var options *mux.Router
+	if config.cors != nil {
+		options = d.PathPrefix(config.apiPrefix).Methods(http.MethodOptions).Schemes(config.scheme).Subrouter()
+	}
+
+	upgrader := config.wsUpgrader
+	if upgrader == nil {
+		upgrader = defaultWebSocketUpgrader(config.cors)
+	}
+	s.wsConns = map[*websocket.Conn]struct{}{}
 
 	registered := map[string]bool{}
+	var wsPaths []string
 	for _, spec := range config.specs {
 		if _, ok := registered[spec.prefix]; ok {
 			return fmt.Errorf("attempt to register %s twice", spec.prefix)
@@ -60,20 +92,93 @@ func (s *Server) init(config *Config) error {
 			registered[spec.prefix] = true
 		}
 
-		s.addSpecification(spec, get, post)
+		s.addSpecification(spec, routers, options, config.middlewares, config.cors, config.defaultRequestTimeout, config.requestTimeoutMessage)
+		wsPaths = append(wsPaths, s.addWebSocketSpecification(spec.prefix, routers[http.MethodGet], spec.sockets, upgrader)...)
 	}
 
 	// Add healthcheck
-	get.HandleFunc(fmt.Sprintf("/%s", config.healthPath), config.hc).Methods("GET")
+	healthRoute := routers[http.MethodGet].HandleFunc(fmt.Sprintf("/%s", config.healthPath), config.hc).Methods(http.MethodGet)
+
+	// Bound the number of requests handled concurrently before any
+	// APIPath is dispatched to, so long-running or excess requests can't
+	// starve the rest of the API. The healthcheck is exempt, so the
+	// in-flight count it reports reflects real API load rather than its
+	// own probing; the metrics endpoint is exempt so scrapes still succeed
+	// under saturation, when the signal matters most; and every registered
+	// WebSocket path is exempt too, since an open connection would
+	// otherwise hold a slot for its entire lifetime and, given enough of
+	// them, starve the rest of the API. Exemptions are keyed on the full
+	// route template, matching what mux.CurrentRoute reports at request
+	// time, not the fragment passed to HandleFunc.
+	if config.maxInFlight > 0 {
+		exempt := map[string]bool{}
+		if tmpl, err := healthRoute.GetPathTemplate(); err == nil {
+			exempt[tmpl] = true
+		}
+		if metricsRoute != nil {
+			if tmpl, err := metricsRoute.GetPathTemplate(); err == nil {
+				exempt[tmpl] = true
+			}
+		}
+		for _, p := range wsPaths {
+			exempt[p] = true
+		}
+		r.Use(mux.MiddlewareFunc(inFlightLimiter(config.maxInFlight, config.longRunningRE, config.inFlightGauge, exempt)))
+	}
 
 	// Bind to a port and pass our router in
 	s.srv = &http.Server{
 		Handler:      r,
-		Addr:         fmt.Sprintf("127.0.0.1:%s", config.port),
+		Addr:         fmt.Sprintf("%s:%s", config.bindAddress, config.port),
 		WriteTimeout: time.Duration(config.writeTimeout) * time.Second,
 		ReadTimeout:  time.Duration(config.readTimeout) * time.Second,
 	}
 
+	if err := s.initTLS(config); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// initTLS applies TLS configuration to s.srv according to config: plain
+// certificate/key files, ACME-issued certificates via AutoTLS, and/or a
+// client CA for mTLS. It is a no-op if none of these have been configured.
+func (s *Server) initTLS(config *Config) error {
+
+	switch {
+	case len(config.autoTLSDomains) > 0:
+		s.autocertManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.autoTLSDomains...),
+			Cache:      autocert.DirCache(autoTLSCacheDir),
+		}
+		s.srv.TLSConfig = s.autocertManager.TLSConfig()
+		s.useTLS = true
+	case len(config.tlsCertFile) > 0 || len(config.tlsKeyFile) > 0:
+		s.certFile = config.tlsCertFile
+		s.keyFile = config.tlsKeyFile
+		s.useTLS = true
+	}
+
+	if len(config.clientCAFile) > 0 {
+		pem, err := os.ReadFile(config.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read client CA file (%s): %w", config.clientCAFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("could not parse client CA file (%s)", config.clientCAFile)
+		}
+
+		if s.srv.TLSConfig == nil {
+			s.srv.TLSConfig = &tls.Config{}
+		}
+		s.srv.TLSConfig.ClientCAs = pool
+		s.srv.TLSConfig.ClientAuth = config.clientAuthType
+	}
+
 	return nil
 }
 
@@ -131,24 +236,144 @@ func (s *Server) getRetriever(req *http.Request) PathVariableRetriever {
 }
 
 // addMethodSpecification creates a subrouter for the specified prefix and applies
-// the paths to it in the order defined
-func (s *Server) addMethodSpecification(prefix string, r *mux.Router, paths []APIPath) {
+// the paths to it in the order defined. Each handler is wrapped, innermost
+// first, by: the request timeout (path-specific, falling back to
+// defaultTimeout), the RequireJSON guard (if set on the path), the CORS
+// middleware (if configured), the APISpecification's own middlewares, and
+// finally the global middlewares - so that, in FIFO order, global
+// middlewares wrap spec middlewares wrap the actual APIHandler.
+func (s *Server) addMethodSpecification(prefix string, r *mux.Router, paths []APIPath, global []Middleware, spec []Middleware, cors *CORSOptions, methods map[string][]string, defaultTimeout time.Duration, timeoutMsg string) {
 
 	if len(paths) > 0 {
 		api := r.PathPrefix(prefix).Subrouter()
 
 		for _, path := range paths {
-			api.HandleFunc(path.path, func(w http.ResponseWriter, req *http.Request) {
+			path := path
+
+			var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 				path.handler(s.getRetriever(req), w, req)
 			})
+			if d := path.timeout; d > 0 || defaultTimeout > 0 {
+				if d == 0 {
+					d = defaultTimeout
+				}
+				h = http.TimeoutHandler(h, d, timeoutMsg)
+			}
+			if path.requireJSON {
+				h = requireJSONGuard(h)
+			}
+			if cors != nil {
+				h = corsMiddleware(*cors, methods[path.path])(h)
+			}
+			h = chain(spec, h)
+			h = chain(global, h)
+
+			api.Handle(path.path, h)
 		}
 	}
 }
 
-// addSpecification creates GET and POST api handlers
-func (s *Server) addSpecification(spec *APISpecification, getReqs *mux.Router, postReqs *mux.Router) {
-	s.addMethodSpecification(spec.prefix, getReqs, spec.gets)
-	s.addMethodSpecification(spec.prefix, postReqs, spec.posts)
+// addSpecification registers spec's GET, POST, PUT, DELETE and PATCH
+// handlers against the per-method routers, and, if CORS is configured,
+// registers an OPTIONS preflight handler for each of spec's routes.
+func (s *Server) addSpecification(spec *APISpecification, routers map[string]*mux.Router, options *mux.Router, global []Middleware, cors *CORSOptions, defaultTimeout time.Duration, timeoutMsg string) {
+	methods := methodsByPath(spec)
+
+	for method, paths := range spec.paths {
+		s.addMethodSpecification(spec.prefix, routers[method], paths, global, spec.middlewares, cors, methods, defaultTimeout, timeoutMsg)
+	}
+
+	if cors != nil {
+		s.addCORSPreflight(spec.prefix, options, methods, *cors)
+	}
+}
+
+// addCORSPreflight registers an OPTIONS handler for each path in methods,
+// answering CORS preflight requests for the methods it is registered under.
+func (s *Server) addCORSPreflight(prefix string, r *mux.Router, methods map[string][]string, opts CORSOptions) {
+	if r == nil || len(methods) == 0 {
+		return
+	}
+
+	api := r.PathPrefix(prefix).Subrouter()
+	for path, allowed := range methods {
+		api.HandleFunc(path, corsPreflightHandler(opts, allowed))
+	}
+}
+
+// addWebSocketSpecification creates a subrouter for the specified prefix
+// and, for each registered WebSocketPath, upgrades the connection via
+// upgrader before invoking its handler. The upgraded connection is
+// tracked so it can be closed during the Server's graceful-exit window.
+// It returns the route template registered for each path, so callers can
+// exempt them from things like the in-flight limiter, for which a
+// long-lived connection would otherwise hold a slot indefinitely.
+func (s *Server) addWebSocketSpecification(prefix string, r *mux.Router, paths []WebSocketPath, upgrader *websocket.Upgrader) []string {
+
+	var templates []string
+
+	if len(paths) > 0 {
+		api := r.PathPrefix(prefix).Subrouter()
+
+		for _, path := range paths {
+			path := path
+
+			route := api.HandleFunc(path.path, func(w http.ResponseWriter, req *http.Request) {
+				conn, err := upgrader.Upgrade(w, req, nil)
+				if err != nil {
+					s.logger.Printf("websocket upgrade failed for %s: %v", req.URL.Path, err)
+					return
+				}
+				defer conn.Close()
+
+				s.trackWSConn(conn)
+				defer s.untrackWSConn(conn)
+
+				path.handler(s.getRetriever(req), conn, req)
+			})
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				templates = append(templates, tmpl)
+			}
+		}
+	}
+
+	return templates
+}
+
+// trackWSConn records conn as active, so it is closed during shutdown.
+func (s *Server) trackWSConn(conn *websocket.Conn) {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	s.wsConns[conn] = struct{}{}
+}
+
+// untrackWSConn removes conn from the set of connections closed during shutdown.
+func (s *Server) untrackWSConn(conn *websocket.Conn) {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	delete(s.wsConns, conn)
+}
+
+// closeWSConns closes every currently tracked WebSocket connection.
+func (s *Server) closeWSConns() {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	for conn := range s.wsConns {
+		conn.Close()
+	}
+}
+
+// requireJSONGuard rejects requests that do not declare
+// "Content-Type: application/json", used for paths registered with
+// RequireJSON().
+func requireJSONGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // Start causes the Server to start handling requests
@@ -158,7 +383,16 @@ func (s *Server) Start() {
 
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
-		if err := s.srv.ListenAndServe(); err != nil {
+		var err error
+		switch {
+		case s.autocertManager != nil:
+			err = s.srv.ListenAndServeTLS("", "")
+		case s.useTLS:
+			err = s.srv.ListenAndServeTLS(s.certFile, s.keyFile)
+		default:
+			err = s.srv.ListenAndServe()
+		}
+		if err != nil {
 			log.Println(err)
 		}
 	}()
@@ -173,8 +407,11 @@ func (s *Server) Start() {
 
 	s.logger.Println("stopping...")
 
+	// Close any open WebSocket connections so they don't block shutdown.
+	s.closeWSConns()
+
 	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.exitTimeout))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.exitTimeout)*time.Second)
 	defer cancel()
 
 	// Doesn't block if no connections, but will otherwise wait
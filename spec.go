@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // PathVariableRetriever provides a mechanism to return a defaultable path value
@@ -14,56 +15,174 @@ type APIHandler func(retriever PathVariableRetriever, w http.ResponseWriter, r *
 
 // APIPath describes a given path (which may include variables) and its handler
 type APIPath struct {
+	path        string
+	handler     APIHandler
+	requireJSON bool
+	timeout     time.Duration
+}
+
+// PathOption configures how an APIPath is registered, via AddPostPath,
+// AddPutPath, AddDeletePath or AddPatchPath.
+type PathOption func(*APIPath)
+
+// RequireJSON marks a path as requiring the request to carry a
+// "Content-Type: application/json" header; requests that don't are
+// rejected with 415 Unsupported Media Type before the handler is invoked.
+func RequireJSON() PathOption {
+	return func(p *APIPath) {
+		p.requireJSON = true
+	}
+}
+
+// withTimeout sets a per-path request timeout, used internally by the
+// AddXPathWithTimeout methods. It overrides Config.DefaultRequestTimeout
+// for this path.
+func withTimeout(d time.Duration) PathOption {
+	return func(p *APIPath) {
+		p.timeout = d
+	}
+}
+
+// WebSocketPath describes a given WebSocket upgrade path (which may
+// include variables) and its handler
+type WebSocketPath struct {
 	path    string
-	handler APIHandler
+	handler WebSocketHandler
 }
 
 // APISpecification describes the structure of an API
 type APISpecification struct {
-	l      *log.Logger
-	prefix string
-	gets   []APIPath
-	posts  []APIPath
+	l           *log.Logger
+	prefix      string
+	paths       map[string][]APIPath
+	sockets     []WebSocketPath
+	middlewares []Middleware
 }
 
-// AddGetPath adds a new GET method APIPath to the APISpecification.
-// If the path already exists then the APISpecification panics
-func (a *APISpecification) AddGetPath(path string, h APIHandler) *APISpecification {
+// Use appends a Middleware to the APISpecification's chain, applied around
+// every APIHandler registered on this specification. Middlewares run in
+// the order they are added: the first added is the outermost, and runs
+// before those added later. See Config.Use for how this chain composes
+// with global middlewares.
+func (a *APISpecification) Use(mw Middleware) *APISpecification {
+	a.middlewares = append(a.middlewares, mw)
+	return a
+}
+
+// addPath registers a new APIPath for method on the APISpecification.
+// If the path already exists for that method then the APISpecification panics
+func (a *APISpecification) addPath(method, path string, h APIHandler, opts ...PathOption) *APISpecification {
 
 	path = strings.ToLower(path)
 
-	for _, p := range a.gets {
+	for _, p := range a.paths[method] {
 		if path == p.path {
-			a.l.Panicf("duplicate GET path: %s", path)
+			a.l.Panicf("duplicate %s path: %s", method, path)
 		}
 	}
 
-	a.gets = append(a.gets,
-		APIPath{
-			path:    path,
-			handler: h,
-		})
+	p := APIPath{
+		path:    path,
+		handler: h,
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	a.paths[method] = append(a.paths[method], p)
 
 	return a
 }
 
-// AddGetPath adds a new POST method APIPath to the APISpecification.
+// AddGetPath adds a new GET method APIPath to the APISpecification.
 // If the path already exists then the APISpecification panics
-func (a *APISpecification) AddPostPath(path string, h APIHandler) *APISpecification {
+func (a *APISpecification) AddGetPath(path string, h APIHandler) *APISpecification {
+	return a.addPath(http.MethodGet, path, h)
+}
+
+// AddGetPathWithTimeout is AddGetPath, but the request is cancelled and
+// a 503 Service Unavailable returned if the handler takes longer than d.
+// It overrides Config.DefaultRequestTimeout for this path.
+func (a *APISpecification) AddGetPathWithTimeout(path string, h APIHandler, d time.Duration) *APISpecification {
+	return a.addPath(http.MethodGet, path, h, withTimeout(d))
+}
+
+// AddPostPath adds a new POST method APIPath to the APISpecification.
+// If the path already exists then the APISpecification panics. By default
+// the request body's Content-Type is not checked; pass RequireJSON() to
+// require "Content-Type: application/json".
+func (a *APISpecification) AddPostPath(path string, h APIHandler, opts ...PathOption) *APISpecification {
+	return a.addPath(http.MethodPost, path, h, opts...)
+}
+
+// AddPostPathWithTimeout is AddPostPath, but the request is cancelled and
+// a 503 Service Unavailable returned if the handler takes longer than d.
+// It overrides Config.DefaultRequestTimeout for this path.
+func (a *APISpecification) AddPostPathWithTimeout(path string, h APIHandler, d time.Duration, opts ...PathOption) *APISpecification {
+	return a.addPath(http.MethodPost, path, h, append(opts, withTimeout(d))...)
+}
+
+// AddPutPath adds a new PUT method APIPath to the APISpecification.
+// If the path already exists then the APISpecification panics. Pass
+// RequireJSON() to require "Content-Type: application/json".
+func (a *APISpecification) AddPutPath(path string, h APIHandler, opts ...PathOption) *APISpecification {
+	return a.addPath(http.MethodPut, path, h, opts...)
+}
+
+// AddPutPathWithTimeout is AddPutPath, but the request is cancelled and
+// a 503 Service Unavailable returned if the handler takes longer than d.
+// It overrides Config.DefaultRequestTimeout for this path.
+func (a *APISpecification) AddPutPathWithTimeout(path string, h APIHandler, d time.Duration, opts ...PathOption) *APISpecification {
+	return a.addPath(http.MethodPut, path, h, append(opts, withTimeout(d))...)
+}
+
+// AddDeletePath adds a new DELETE method APIPath to the APISpecification.
+// If the path already exists then the APISpecification panics. Pass
+// RequireJSON() to require "Content-Type: application/json".
+func (a *APISpecification) AddDeletePath(path string, h APIHandler, opts ...PathOption) *APISpecification {
+	return a.addPath(http.MethodDelete, path, h, opts...)
+}
+
+// AddDeletePathWithTimeout is AddDeletePath, but the request is cancelled
+// and a 503 Service Unavailable returned if the handler takes longer than
+// d. It overrides Config.DefaultRequestTimeout for this path.
+func (a *APISpecification) AddDeletePathWithTimeout(path string, h APIHandler, d time.Duration, opts ...PathOption) *APISpecification {
+	return a.addPath(http.MethodDelete, path, h, append(opts, withTimeout(d))...)
+}
+
+// AddPatchPath adds a new PATCH method APIPath to the APISpecification.
+// If the path already exists then the APISpecification panics. Pass
+// RequireJSON() to require "Content-Type: application/json".
+func (a *APISpecification) AddPatchPath(path string, h APIHandler, opts ...PathOption) *APISpecification {
+	return a.addPath(http.MethodPatch, path, h, opts...)
+}
+
+// AddPatchPathWithTimeout is AddPatchPath, but the request is cancelled
+// and a 503 Service Unavailable returned if the handler takes longer than
+// d. It overrides Config.DefaultRequestTimeout for this path.
+func (a *APISpecification) AddPatchPathWithTimeout(path string, h APIHandler, d time.Duration, opts ...PathOption) *APISpecification {
+	return a.addPath(http.MethodPatch, path, h, append(opts, withTimeout(d))...)
+}
+
+// AddWebSocketPath adds a new WebSocket upgrade path to the
+// APISpecification. If the path already exists then the APISpecification
+// panics. Unlike the AddXPath methods, the connection is upgraded before
+// h is invoked, so h receives the open *websocket.Conn rather than an
+// http.ResponseWriter.
+func (a *APISpecification) AddWebSocketPath(path string, h WebSocketHandler) *APISpecification {
 
 	path = strings.ToLower(path)
 
-	for _, p := range a.posts {
+	for _, p := range a.sockets {
 		if path == p.path {
-			a.l.Panicf("duplicate POST path: %s", path)
+			a.l.Panicf("duplicate WEBSOCKET path: %s", path)
 		}
 	}
 
-	a.posts = append(a.posts,
-		APIPath{
-			path:    path,
-			handler: h,
-		})
+	a.sockets = append(a.sockets, WebSocketPath{
+		path:    path,
+		handler: h,
+	})
 
 	return a
 }
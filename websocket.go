@@ -0,0 +1,32 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketHandler handles an upgraded WebSocket connection registered via
+// APISpecification.AddWebSocketPath.
+type WebSocketHandler func(retriever PathVariableRetriever, conn *websocket.Conn, r *http.Request)
+
+// defaultWebSocketUpgrader returns the Upgrader used when
+// Config.WebSocketUpgrader has not been set: it rejects cross-origin
+// upgrade requests unless Config.CORS has explicitly whitelisted the
+// origin.
+func defaultWebSocketUpgrader(cors *CORSOptions) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if len(origin) == 0 {
+				return true
+			}
+			if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+				return true
+			}
+			return cors != nil && cors.originAllowed(origin)
+		},
+	}
+}